@@ -0,0 +1,291 @@
+// Copyright 2023 Google LLC. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package googleapi
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// The well-known google.rpc error detail types, keyed by their "@type" URL
+// as they appear in an Error's Details slice.
+// See https://github.com/googleapis/googleapis/blob/master/google/rpc/error_details.proto.
+const (
+	typeErrorInfo           = "type.googleapis.com/google.rpc.ErrorInfo"
+	typeRetryInfo           = "type.googleapis.com/google.rpc.RetryInfo"
+	typeQuotaFailure        = "type.googleapis.com/google.rpc.QuotaFailure"
+	typeBadRequest          = "type.googleapis.com/google.rpc.BadRequest"
+	typePreconditionFailure = "type.googleapis.com/google.rpc.PreconditionFailure"
+	typeResourceInfo        = "type.googleapis.com/google.rpc.ResourceInfo"
+	typeRequestInfo         = "type.googleapis.com/google.rpc.RequestInfo"
+	typeDebugInfo           = "type.googleapis.com/google.rpc.DebugInfo"
+	typeHelp                = "type.googleapis.com/google.rpc.Help"
+	typeLocalizedMessage    = "type.googleapis.com/google.rpc.LocalizedMessage"
+)
+
+// ErrorInfo describes the cause of an error with structured details.
+type ErrorInfo struct {
+	Reason   string            `json:"reason"`
+	Domain   string            `json:"domain"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// RetryInfo describes when clients can retry a failed request.
+type RetryInfo struct {
+	RetryDelay time.Duration
+}
+
+// UnmarshalJSON parses the protobuf-duration-style "retryDelay" string
+// (e.g. "1.500s") into RetryDelay.
+func (r *RetryInfo) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		RetryDelay string `json:"retryDelay"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.RetryDelay == "" {
+		r.RetryDelay = 0
+		return nil
+	}
+	d, err := time.ParseDuration(raw.RetryDelay)
+	if err != nil {
+		return err
+	}
+	r.RetryDelay = d
+	return nil
+}
+
+// QuotaViolation identifies a single quota violation.
+type QuotaViolation struct {
+	Subject     string `json:"subject"`
+	Description string `json:"description"`
+}
+
+// QuotaFailure describes how a quota check failed.
+type QuotaFailure struct {
+	Violations []QuotaViolation `json:"violations"`
+}
+
+// FieldViolation describes a single bad request field.
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// BadRequest describes violations in a client request.
+type BadRequest struct {
+	FieldViolations []FieldViolation `json:"fieldViolations"`
+}
+
+// PreconditionViolation describes a single precondition failure.
+type PreconditionViolation struct {
+	Type        string `json:"type"`
+	Subject     string `json:"subject"`
+	Description string `json:"description"`
+}
+
+// PreconditionFailure describes how a precondition check failed.
+type PreconditionFailure struct {
+	Violations []PreconditionViolation `json:"violations"`
+}
+
+// ResourceInfo describes the resource that is being accessed.
+type ResourceInfo struct {
+	ResourceType string `json:"resourceType"`
+	ResourceName string `json:"resourceName"`
+	Owner        string `json:"owner"`
+	Description  string `json:"description"`
+}
+
+// RequestInfo contains metadata about the request that clients can attach
+// when filing a bug or providing other forms of feedback.
+type RequestInfo struct {
+	RequestId   string `json:"requestId"`
+	ServingData string `json:"servingData"`
+}
+
+// DebugInfo describes additional debugging info.
+type DebugInfo struct {
+	StackEntries []string `json:"stackEntries"`
+	Detail       string   `json:"detail"`
+}
+
+// HelpLink describes a URL link.
+type HelpLink struct {
+	Description string `json:"description"`
+	Url         string `json:"url"`
+}
+
+// Help provides links to documentation or for performing an out-of-band
+// action.
+type Help struct {
+	Links []HelpLink `json:"links"`
+}
+
+// LocalizedMessage provides a localized error message.
+type LocalizedMessage struct {
+	Locale  string `json:"locale"`
+	Message string `json:"message"`
+}
+
+// detail finds the first entry in e.Details whose "@type" matches want and
+// unmarshals it into target, which must be a pointer. It reports whether a
+// matching, successfully decoded detail was found. e.Details itself is
+// left untouched, so Error remains comparable with reflect.DeepEqual as it
+// was before typed accessors existed.
+func (e *Error) detail(want string, target interface{}) bool {
+	for _, d := range e.Details {
+		m, ok := d.(map[string]interface{})
+		if !ok || m["@type"] != want {
+			continue
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			return false
+		}
+		return json.Unmarshal(b, target) == nil
+	}
+	return false
+}
+
+// ErrorInfo returns the ErrorInfo detail attached to e, if any.
+func (e *Error) ErrorInfo() (*ErrorInfo, bool) {
+	var v ErrorInfo
+	if !e.detail(typeErrorInfo, &v) {
+		return nil, false
+	}
+	return &v, true
+}
+
+// RetryInfo returns the RetryInfo detail attached to e, if any.
+func (e *Error) RetryInfo() (*RetryInfo, bool) {
+	var v RetryInfo
+	if !e.detail(typeRetryInfo, &v) {
+		return nil, false
+	}
+	return &v, true
+}
+
+// QuotaFailure returns the QuotaFailure detail attached to e, if any.
+func (e *Error) QuotaFailure() (*QuotaFailure, bool) {
+	var v QuotaFailure
+	if !e.detail(typeQuotaFailure, &v) {
+		return nil, false
+	}
+	return &v, true
+}
+
+// BadRequest returns the BadRequest detail attached to e, if any.
+func (e *Error) BadRequest() (*BadRequest, bool) {
+	var v BadRequest
+	if !e.detail(typeBadRequest, &v) {
+		return nil, false
+	}
+	return &v, true
+}
+
+// PreconditionFailure returns the PreconditionFailure detail attached to
+// e, if any.
+func (e *Error) PreconditionFailure() (*PreconditionFailure, bool) {
+	var v PreconditionFailure
+	if !e.detail(typePreconditionFailure, &v) {
+		return nil, false
+	}
+	return &v, true
+}
+
+// ResourceInfo returns the ResourceInfo detail attached to e, if any.
+func (e *Error) ResourceInfo() (*ResourceInfo, bool) {
+	var v ResourceInfo
+	if !e.detail(typeResourceInfo, &v) {
+		return nil, false
+	}
+	return &v, true
+}
+
+// RequestInfo returns the RequestInfo detail attached to e, if any.
+func (e *Error) RequestInfo() (*RequestInfo, bool) {
+	var v RequestInfo
+	if !e.detail(typeRequestInfo, &v) {
+		return nil, false
+	}
+	return &v, true
+}
+
+// DebugInfo returns the DebugInfo detail attached to e, if any.
+func (e *Error) DebugInfo() (*DebugInfo, bool) {
+	var v DebugInfo
+	if !e.detail(typeDebugInfo, &v) {
+		return nil, false
+	}
+	return &v, true
+}
+
+// Help returns the Help detail attached to e, if any.
+func (e *Error) Help() (*Help, bool) {
+	var v Help
+	if !e.detail(typeHelp, &v) {
+		return nil, false
+	}
+	return &v, true
+}
+
+// LocalizedMessage returns the LocalizedMessage detail attached to e, if
+// any.
+func (e *Error) LocalizedMessage() (*LocalizedMessage, bool) {
+	var v LocalizedMessage
+	if !e.detail(typeLocalizedMessage, &v) {
+		return nil, false
+	}
+	return &v, true
+}
+
+// detailTypeFor returns the "@type" URL that corresponds to the dynamic
+// type of target, which must be one of the pointer types returned by the
+// typed accessors above.
+func detailTypeFor(target interface{}) (string, bool) {
+	switch target.(type) {
+	case *ErrorInfo:
+		return typeErrorInfo, true
+	case *RetryInfo:
+		return typeRetryInfo, true
+	case *QuotaFailure:
+		return typeQuotaFailure, true
+	case *BadRequest:
+		return typeBadRequest, true
+	case *PreconditionFailure:
+		return typePreconditionFailure, true
+	case *ResourceInfo:
+		return typeResourceInfo, true
+	case *RequestInfo:
+		return typeRequestInfo, true
+	case *DebugInfo:
+		return typeDebugInfo, true
+	case *Help:
+		return typeHelp, true
+	case *LocalizedMessage:
+		return typeLocalizedMessage, true
+	default:
+		return "", false
+	}
+}
+
+// DetailInto unmarshals the first entry of e.Details whose "@type" matches
+// target's well-known google.rpc type into target. target must be a
+// pointer to one of *ErrorInfo, *RetryInfo, *QuotaFailure, *BadRequest,
+// *PreconditionFailure, *ResourceInfo, *RequestInfo, *DebugInfo, *Help, or
+// *LocalizedMessage. It reports whether a matching detail was found and
+// decoded.
+//
+// It is named DetailInto, rather than Details, to avoid colliding with
+// the Error.Details field.
+func (e *Error) DetailInto(target interface{}) bool {
+	t, ok := detailTypeFor(target)
+	if !ok {
+		return false
+	}
+	return e.detail(t, target)
+}
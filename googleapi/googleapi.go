@@ -0,0 +1,280 @@
+// Copyright 2011 Google LLC. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package googleapi contains the common code shared by generated
+// Google API clients.
+package googleapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi/internal/uritemplates"
+)
+
+// Error contains an error response from the server.
+type Error struct {
+	// Code is the HTTP response status code and will always be populated.
+	Code int `json:"code"`
+	// Message is the server response message and is only populated when
+	// explicitly referenced by the JSON server response.
+	Message string `json:"message"`
+	// Body is the raw response returned by the server.
+	// It is often but not always JSON, depending on how the request fails.
+	Body string
+	// Header contains the response header fields from the server.
+	Header http.Header
+
+	Errors []ErrorItem
+
+	// Details provide more context to an error.
+	Details []interface{} `json:"details"`
+}
+
+// ErrorItem is a detailed error code & message from the Google API frontend.
+type ErrorItem struct {
+	// Reason is the typed error code. For example: "some_example".
+	Reason string `json:"reason"`
+	// Message is the human-readable description of the error.
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code != 0 && e.Message == "" {
+		return fmt.Sprintf("googleapi: got HTTP response code %d with body: %v", e.Code, e.Body)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "googleapi: Error %d: %s", e.Code, e.Message)
+	if len(e.Errors) > 0 {
+		fmt.Fprintf(&buf, ", %s", e.Errors[0].Reason)
+	}
+	if len(e.Details) > 0 {
+		metaDetails, err := json.MarshalIndent(e.Details, "", "  ")
+		if err == nil {
+			fmt.Fprintf(&buf, "\nDetails:\n%s", metaDetails)
+		}
+	}
+	return buf.String()
+}
+
+// errorReply is the struct a server error JSON body unmarshals into.
+type errorReply struct {
+	Error *Error `json:"error"`
+}
+
+// CheckResponse returns an error (of type *Error) if the response
+// status code is not 2xx.
+func CheckResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return nil
+	}
+	slurp, err := io.ReadAll(res.Body)
+	if err == nil {
+		res.Body = io.NopCloser(bytes.NewBuffer(slurp))
+		return CheckResponseWithBody(res, slurp)
+	}
+	return &Error{
+		Code: res.StatusCode,
+	}
+}
+
+// CheckResponseWithBody returns an error (of type *Error) if the response
+// status code is not 2xx, using body as the already-read response body.
+// It's split out from CheckResponse so callers that need to inspect the
+// body themselves don't have to read it twice.
+func CheckResponseWithBody(res *http.Response, body []byte) error {
+	if res.StatusCode >= 200 && res.StatusCode <= 299 {
+		return nil
+	}
+	jerr := new(errorReply)
+	if err := json.Unmarshal(body, jerr); err != nil || jerr.Error == nil {
+		var arr []errorReply
+		if err2 := json.Unmarshal(body, &arr); err2 == nil && len(arr) > 0 && arr[0].Error != nil {
+			jerr = &arr[0]
+		}
+	}
+	if jerr.Error == nil {
+		jerr.Error = &Error{}
+	}
+	jerr.Error.Code = res.StatusCode
+	jerr.Error.Body = string(body)
+	jerr.Error.Header = res.Header
+	return jerr.Error
+}
+
+// VariantType returns the type name of the given variant object, whose
+// structure is defined by the API's discovery document. It's the value
+// of the "type" key in the object, if any.
+func VariantType(t map[string]interface{}) string {
+	s, _ := t["type"].(string)
+	return s
+}
+
+// ConvertVariant converts a map[string]interface{} disguised as a
+// variant to a variant. It is used when a JSON structure's type is
+// not known in advance, such as a union of several possible shapes
+// described in a Discovery document.
+func ConvertVariant(v map[string]interface{}, dst interface{}) bool {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(b, dst) == nil
+}
+
+// Expand subsitutes any {encoded} strings in the URL passed in using
+// the map supplied.
+//
+// This calls uritemplates.Expand, the expander behind ExpandTemplate, and
+// keeps u.Path and u.RawPath in
+// sync so that u.EscapedPath() returns the exact RFC 6570 expansion without
+// a second round of percent-encoding.
+func Expand(u *url.URL, expansions map[string]string) {
+	values := make(map[string]interface{}, len(expansions))
+	for k, v := range expansions {
+		values[k] = v
+	}
+	escaped, unescaped, err := uritemplates.Expand(u.Path, values)
+	if err != nil {
+		return
+	}
+	u.Path = unescaped
+	u.RawPath = escaped
+}
+
+// ExpandTemplate expands pattern as an RFC 6570 URI Template (Levels 1-4)
+// using vars, returning the percent-escaped result.
+//
+// vars values may be strings, []string (for the explode/list forms) or
+// map[string]string (for the associative-array forms). A variable missing
+// from vars, or present with an empty list or map, contributes nothing to
+// the expansion. ExpandTemplate returns an error if pattern contains a
+// malformed expression, such as an unsupported operator or variable name.
+// A "{" with no closing "}" is not an error: it and the rest of pattern
+// are copied through unchanged.
+func ExpandTemplate(pattern string, vars map[string]interface{}) (string, error) {
+	escaped, _, err := uritemplates.Expand(pattern, vars)
+	if err != nil {
+		return "", err
+	}
+	return escaped, nil
+}
+
+// ResolveRelative resolves relatives such as "http://www.golang.org/" and
+// "topics/myproject/mytopic" into a single string, such as
+// "http://www.golang.org/topics/myproject/mytopic". It strips all parent
+// references (e.g. ../..) as well as anything after the host (e.g.,
+// /bar/gif?a=b from "foo.com/bar/gif?a=b").
+func ResolveRelative(basestr, relstr string) string {
+	u, err := url.Parse(basestr)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse %q: %v", basestr, err))
+	}
+	afterColonPath := ""
+	if i := strings.Index(relstr, ":"); i > 0 {
+		afterColonPath = relstr[i+1:]
+		relstr = relstr[:i]
+	}
+	rel, err := url.Parse(relstr)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse %q: %v", relstr, err))
+	}
+	us := u.ResolveReference(rel).String()
+	if afterColonPath != "" {
+		us = fmt.Sprintf("%s:%s", us, afterColonPath)
+	}
+	us = strings.Replace(us, "%7B", "{", -1)
+	us = strings.Replace(us, "%7D", "}", -1)
+	us = strings.Replace(us, "%2A", "*", -1)
+	return us
+}
+
+// MediaOption configures media upload requests.
+type MediaOption interface {
+	setOptions(o *MediaOptions)
+}
+
+// MediaOptions stores options for customizing media upload requests.
+type MediaOptions struct {
+	ContentType string
+	ChunkSize   int
+
+	// Adaptive reports whether ChunkSize should be grown or shrunk
+	// automatically between AdaptiveMinChunkSize and AdaptiveMaxChunkSize
+	// as an upload progresses. Set via AdaptiveChunkSize.
+	Adaptive             bool
+	AdaptiveMinChunkSize int
+	AdaptiveMaxChunkSize int
+
+	// ParallelChunks is the number of chunks to upload concurrently. A
+	// value less than 2 uploads sequentially. Set via ParallelChunks.
+	ParallelChunks int
+
+	// ChunkRetryBudget bounds how long a single chunk may be retried
+	// before the upload gives up and returns an error. Set via
+	// ChunkRetryBudget.
+	ChunkRetryBudget time.Duration
+}
+
+type contentTypeOption string
+
+func (ct contentTypeOption) setOptions(o *MediaOptions) {
+	if ct == "" {
+		o.ContentType = "application/octet-stream"
+	} else {
+		o.ContentType = string(ct)
+	}
+}
+
+// ContentType, when passed as an option to MediaOptions, sets the
+// Content-Type of the media upload request. Defaults to
+// "application/octet-stream".
+func ContentType(ctype string) MediaOption {
+	return contentTypeOption(ctype)
+}
+
+// MinUploadChunkSize is the smallest unit (in bytes) the resumable
+// upload protocol operates on; every chunk size must be a multiple of
+// it.
+const MinUploadChunkSize = 256 * 1024
+
+// roundUpChunkSize rounds size up to the nearest multiple of
+// MinUploadChunkSize.
+func roundUpChunkSize(size int) int {
+	if size%MinUploadChunkSize != 0 {
+		size += MinUploadChunkSize - (size % MinUploadChunkSize)
+	}
+	return size
+}
+
+type chunkSizeOption int
+
+func (cs chunkSizeOption) setOptions(o *MediaOptions) {
+	o.ChunkSize = roundUpChunkSize(int(cs))
+}
+
+// ChunkSize, when passed as an option to MediaOptions, sets the chunk
+// size (in bytes) used by the resumable upload protocol. It will be
+// rounded up to the nearest multiple of MinUploadChunkSize. A ChunkSize
+// of zero causes the media to be uploaded in a single request.
+func ChunkSize(size int) MediaOption {
+	return chunkSizeOption(size)
+}
+
+// ProcessMediaOptions stores options from opts in MediaOptions.
+// It is only exported to support generated code and should not be used
+// directly.
+func ProcessMediaOptions(opts []MediaOption) *MediaOptions {
+	mo := &MediaOptions{ContentType: "application/octet-stream"}
+	for _, o := range opts {
+		o.setOptions(mo)
+	}
+	return mo
+}
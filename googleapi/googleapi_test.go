@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type ExpandTest struct {
@@ -467,3 +468,169 @@ func TestRoundChunkSize(t *testing.T) {
 		}
 	}
 }
+
+type ExpandTemplateTest struct {
+	pattern string
+	vars    map[string]interface{}
+	want    string
+	wantErr bool
+}
+
+var expandTemplateTests = []ExpandTemplateTest{
+	// Level 1: simple string expansion.
+	{
+		pattern: "{var}",
+		vars:    map[string]interface{}{"var": "value"},
+		want:    "value",
+	},
+	// Level 2: "+" reserved expansion and "#" fragment expansion.
+	{
+		pattern: "{+path}/here",
+		vars:    map[string]interface{}{"path": "/foo/bar"},
+		want:    "/foo/bar/here",
+	},
+	{
+		pattern: "X{#path}",
+		vars:    map[string]interface{}{"path": "/foo/bar"},
+		want:    "X#/foo/bar",
+	},
+	// Level 3: multiple variables and the ".", "/", ";", "?", "&" operators.
+	{
+		pattern: "{.who}",
+		vars:    map[string]interface{}{"who": "fred"},
+		want:    ".fred",
+	},
+	{
+		pattern: "customer/{customerId}/orgunits{/orgUnitPath*}",
+		vars: map[string]interface{}{
+			"customerId":  "C123",
+			"orgUnitPath": []string{"corp", "support"},
+		},
+		want: "customer/C123/orgunits/corp/support",
+	},
+	{
+		pattern: "{;x,y}",
+		vars:    map[string]interface{}{"x": "1024", "y": "768"},
+		want:    ";x=1024;y=768",
+	},
+	{
+		pattern: "{?fields,alt}",
+		vars:    map[string]interface{}{"fields": "*", "alt": "json"},
+		want:    "?fields=%2A&alt=json",
+	},
+	{
+		pattern: "{?fields,alt}",
+		vars:    map[string]interface{}{},
+		want:    "",
+	},
+	{
+		pattern: "find{&empty}",
+		vars:    map[string]interface{}{"empty": []string{}},
+		want:    "find",
+	},
+	// Level 4: explode and prefix modifiers, plus associative arrays.
+	{
+		pattern: "{path:6}",
+		vars:    map[string]interface{}{"path": "/foo/bar"},
+		want:    "%2Ffoo%2Fb",
+	},
+	{
+		pattern: "{+path}:create_version",
+		vars:    map[string]interface{}{"path": "tagmanager/v2/accounts/1"},
+		want:    "tagmanager/v2/accounts/1:create_version",
+	},
+	{
+		pattern: "{;list*}",
+		vars:    map[string]interface{}{"list": map[string]string{"a": "1", "b": "2"}},
+		want:    ";a=1;b=2",
+	},
+	// Undefined variables are omitted, not expanded to an empty segment.
+	{
+		pattern: "{undefined}tail",
+		vars:    map[string]interface{}{},
+		want:    "tail",
+	},
+	// Malformed expressions are errors, not literal pass-through.
+	{
+		pattern: "{}",
+		wantErr: true,
+	},
+	{
+		pattern: "{=foo}",
+		wantErr: true,
+	},
+	{
+		pattern: "{var:}",
+		wantErr: true,
+	},
+}
+
+func TestExpandTemplate(t *testing.T) {
+	for i, test := range expandTemplateTests {
+		got, err := ExpandTemplate(test.pattern, test.vars)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("test %d: ExpandTemplate(%q) = %q, want error", i, test.pattern, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d: ExpandTemplate(%q) returned unexpected error: %v", i, test.pattern, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("test %d: ExpandTemplate(%q) = %q, want %q", i, test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestErrorDetails(t *testing.T) {
+	err := &Error{
+		Code:    http.StatusTooManyRequests,
+		Message: "Resource has been exhausted.",
+		Details: []interface{}{
+			map[string]interface{}{
+				"@type":      "type.googleapis.com/google.rpc.RetryInfo",
+				"retryDelay": "1.500s",
+			},
+			map[string]interface{}{
+				"@type": "type.googleapis.com/google.rpc.QuotaFailure",
+				"violations": []interface{}{
+					map[string]interface{}{
+						"subject":     "project:my-project",
+						"description": "Limit exceeded.",
+					},
+				},
+			},
+		},
+	}
+
+	retry, ok := err.RetryInfo()
+	if !ok {
+		t.Fatal("RetryInfo() = _, false, want true")
+	}
+	if want := 1500 * time.Millisecond; retry.RetryDelay != want {
+		t.Errorf("RetryInfo().RetryDelay = %v, want %v", retry.RetryDelay, want)
+	}
+
+	quota, ok := err.QuotaFailure()
+	if !ok {
+		t.Fatal("QuotaFailure() = _, false, want true")
+	}
+	want := []QuotaViolation{{Subject: "project:my-project", Description: "Limit exceeded."}}
+	if !reflect.DeepEqual(quota.Violations, want) {
+		t.Errorf("QuotaFailure().Violations = %v, want %v", quota.Violations, want)
+	}
+
+	if _, ok := err.BadRequest(); ok {
+		t.Error("BadRequest() = _, true, want false")
+	}
+
+	var generic RetryInfo
+	if !err.DetailInto(&generic) {
+		t.Fatal("DetailInto(&RetryInfo{}) = false, want true")
+	}
+	if generic.RetryDelay != 1500*time.Millisecond {
+		t.Errorf("DetailInto(&RetryInfo{}).RetryDelay = %v, want %v", generic.RetryDelay, 1500*time.Millisecond)
+	}
+}
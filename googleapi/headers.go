@@ -0,0 +1,103 @@
+// Copyright 2023 Google LLC. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package googleapi
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultExtensionHeaderPrefixes are the header name prefixes
+// CanonicalExtensionHeaders and SanitizeExtensionHeaders consider by
+// default: the "x-goog-" extension headers used by signed-URL and V4
+// signing flows.
+var DefaultExtensionHeaderPrefixes = []string{"x-goog-"}
+
+var headerWhitespace = regexp.MustCompile(`\s+`)
+
+// CanonicalExtensionHeaders returns the "canonical extension header"
+// lines for h, following the same rules used to sign Google Cloud
+// Storage signed URLs: header names are lowercased, headers with empty
+// values are dropped, repeated internal whitespace is collapsed to a
+// single space, multi-valued headers are joined with a single comma, and
+// the resulting "name:value" lines are sorted by name.
+//
+// Only headers whose lowercased name starts with one of prefixes are
+// included; if prefixes is empty, DefaultExtensionHeaderPrefixes is used.
+func CanonicalExtensionHeaders(h http.Header, prefixes ...string) []string {
+	if len(prefixes) == 0 {
+		prefixes = DefaultExtensionHeaderPrefixes
+	}
+
+	names := make([]string, 0, len(h))
+	lower := make(map[string]string, len(h))
+	for name := range h {
+		l := strings.ToLower(name)
+		if !hasAnyPrefix(l, prefixes) {
+			continue
+		}
+		names = append(names, name)
+		lower[name] = l
+	}
+	sort.Slice(names, func(i, j int) bool { return lower[names[i]] < lower[names[j]] })
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		values := make([]string, 0, len(h[name]))
+		for _, v := range h[name] {
+			v = headerWhitespace.ReplaceAllString(strings.TrimSpace(v), " ")
+			if v == "" {
+				continue
+			}
+			values = append(values, v)
+		}
+		if len(values) == 0 {
+			continue
+		}
+		lines = append(lines, lower[name]+":"+strings.Join(values, ","))
+	}
+	return lines
+}
+
+// SanitizeExtensionHeaders rewrites h in place so that its extension
+// headers carry the canonical combined multi-valued, whitespace-collapsed
+// value computed by CanonicalExtensionHeaders, with empty-valued headers
+// removed. Because this goes through http.Header.Set, the stored key
+// still uses Go's standard MIME header casing (e.g. "X-Goog-Acl"), not
+// the lowercase form CanonicalExtensionHeaders' "name:value" lines use;
+// callers that need the literal lowercase name should call
+// CanonicalExtensionHeaders directly. Headers outside of prefixes
+// (DefaultExtensionHeaderPrefixes if none are given) are left untouched.
+func SanitizeExtensionHeaders(h http.Header, prefixes ...string) {
+	if len(prefixes) == 0 {
+		prefixes = DefaultExtensionHeaderPrefixes
+	}
+
+	lines := CanonicalExtensionHeaders(h, prefixes...)
+
+	for name := range h {
+		if hasAnyPrefix(strings.ToLower(name), prefixes) {
+			h.Del(name)
+		}
+	}
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		h.Set(name, value)
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 Google LLC. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package googleapi
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalExtensionHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Add("X-Goog-Meta-Reviewer", "  alice  ")
+	h.Add("X-Goog-Meta-Reviewer", "bob")
+	h.Set("X-Goog-Acl", "public-read")
+	h.Set("X-Goog-Empty", "")
+	h.Set("Content-Type", "text/plain") // not an extension header, ignored
+	h.Set("X-Goog-Spacey", "a   b\tc")
+
+	got := CanonicalExtensionHeaders(h)
+	want := []string{
+		"x-goog-acl:public-read",
+		"x-goog-meta-reviewer:alice,bob",
+		"x-goog-spacey:a b c",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CanonicalExtensionHeaders = %v, want %v", got, want)
+	}
+}
+
+func TestCanonicalExtensionHeadersCustomPrefix(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-Amz-Date", "20230101T000000Z")
+	h.Set("X-Goog-Acl", "public-read")
+
+	got := CanonicalExtensionHeaders(h, "x-amz-")
+	want := []string{"x-amz-date:20230101T000000Z"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CanonicalExtensionHeaders with custom prefix = %v, want %v", got, want)
+	}
+}
+
+func TestSanitizeExtensionHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Add("X-Goog-Meta-Reviewer", "alice")
+	h.Add("X-Goog-Meta-Reviewer", "bob")
+	h.Set("X-Goog-Empty", "")
+	h.Set("Content-Type", "text/plain")
+
+	SanitizeExtensionHeaders(h)
+
+	if got, want := h.Get("Content-Type"), "text/plain"; got != want {
+		t.Errorf("Content-Type = %q, want %q (untouched)", got, want)
+	}
+	if _, ok := h["X-Goog-Empty"]; ok {
+		t.Error("X-Goog-Empty header should have been dropped")
+	}
+	if got := h.Values("x-goog-meta-reviewer"); len(got) != 1 || got[0] != "alice,bob" {
+		t.Errorf("x-goog-meta-reviewer = %v, want [\"alice,bob\"]", got)
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright 2023 Google LLC. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package uritemplates implements expansion of URI Templates, as defined in
+// RFC 6570 (https://tools.ietf.org/html/rfc6570). It supports Levels 1
+// through 4, including the operators `+`, `#`, `.`, `/`, `;`, `?`, `&`, and
+// the explode (`*`) and prefix (`:N`) variable modifiers.
+package uritemplates
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Expand substitutes the variables found in template with values, returning
+// both a fully percent-escaped rendering of the result and the corresponding
+// literal (unescaped) rendering. Callers that only need one form can ignore
+// the other; googleapi.Expand uses both to populate a url.URL's Path and
+// RawPath without a second encoding pass.
+//
+// Values may be strings, []string (RFC 6570 lists) or map[string]string
+// (RFC 6570 associative arrays).
+//
+// Text outside of `{...}` expressions is copied through unchanged in both
+// return values, since it is assumed to already be valid URI text (e.g. a
+// literal path segment from a Discovery document).
+func Expand(template string, values map[string]interface{}) (escaped, unescaped string, err error) {
+	var eb, ub strings.Builder
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start == -1 {
+			eb.WriteString(rest)
+			ub.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end == -1 {
+			// No matching close brace: treat the rest as a literal, matching
+			// the historical behavior of the simple {var}/{+var} expander.
+			eb.WriteString(rest)
+			ub.WriteString(rest)
+			break
+		}
+		end += start
+
+		eb.WriteString(rest[:start])
+		ub.WriteString(rest[:start])
+
+		e, u, xerr := expandExpression(rest[start+1:end], values)
+		if xerr != nil {
+			return "", "", xerr
+		}
+		eb.WriteString(e)
+		ub.WriteString(u)
+
+		rest = rest[end+1:]
+	}
+	return eb.String(), ub.String(), nil
+}
+
+// operator describes the RFC 6570 expansion rules for a single operator
+// character.
+type operator struct {
+	first         string
+	sep           string
+	named         bool
+	ifemp         string
+	allowReserved bool
+}
+
+var operators = map[byte]operator{
+	'+': {"", ",", false, "", true},
+	'#': {"#", ",", false, "", true},
+	'.': {".", ".", false, "", false},
+	'/': {"/", "/", false, "", false},
+	';': {";", ";", true, "", false},
+	'?': {"?", "&", true, "=", false},
+	'&': {"&", "&", true, "=", false},
+}
+
+// simpleOperator is used for Level 1/2 templates with no leading operator
+// character (e.g. {var}, {var*}).
+var simpleOperator = operator{"", ",", false, "", false}
+
+// reservedOperators are operators defined by RFC 6570 but not implemented
+// here; seeing one is a malformed-expression error rather than a silent
+// pass-through.
+var reservedOperators = map[byte]bool{'=': true, ',': true, '!': true, '@': true, '|': true}
+
+type varspec struct {
+	name      string
+	explode   bool
+	maxLength int // 0 means "no prefix modifier"
+}
+
+func expandExpression(expr string, values map[string]interface{}) (escaped, unescaped string, err error) {
+	if expr == "" {
+		return "", "", fmt.Errorf("uritemplates: empty expression %q", "{}")
+	}
+
+	op := simpleOperator
+	body := expr
+	if o, ok := operators[expr[0]]; ok {
+		op = o
+		body = expr[1:]
+	} else if reservedOperators[expr[0]] {
+		return "", "", fmt.Errorf("uritemplates: unsupported operator %q in %q", string(expr[0]), expr)
+	}
+
+	specs, err := parseVarspecs(body)
+	if err != nil {
+		return "", "", fmt.Errorf("uritemplates: malformed expression %q: %w", expr, err)
+	}
+
+	e := expandWithEncoder(op, specs, values, func(s string) string { return pctEncode(s, op.allowReserved) })
+	u := expandWithEncoder(op, specs, values, func(s string) string { return s })
+	return e, u, nil
+}
+
+func parseVarspecs(body string) ([]varspec, error) {
+	if body == "" {
+		return nil, fmt.Errorf("no variable specified")
+	}
+	parts := strings.Split(body, ",")
+	specs := make([]varspec, 0, len(parts))
+	for _, p := range parts {
+		if p == "" {
+			return nil, fmt.Errorf("empty variable name")
+		}
+		name := p
+		explode := false
+		maxLength := 0
+		if strings.HasSuffix(p, "*") {
+			explode = true
+			name = p[:len(p)-1]
+		} else if i := strings.IndexByte(p, ':'); i >= 0 {
+			name = p[:i]
+			lenStr := p[i+1:]
+			if lenStr == "" || len(lenStr) > 4 {
+				return nil, fmt.Errorf("invalid prefix length in %q", p)
+			}
+			n, err := strconv.Atoi(lenStr)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid prefix length in %q", p)
+			}
+			maxLength = n
+		}
+		if name == "" || !isValidVarname(name) {
+			return nil, fmt.Errorf("invalid variable name %q", name)
+		}
+		specs = append(specs, varspec{name: name, explode: explode, maxLength: maxLength})
+	}
+	return specs, nil
+}
+
+func isValidVarname(name string) bool {
+	for _, r := range name {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// expandWithEncoder renders specs under op, applying encode to every literal
+// value before it is joined into the result. encode is the identity function
+// to obtain the unescaped rendering, or a percent-encoder to obtain the
+// escaped one.
+func expandWithEncoder(op operator, specs []varspec, values map[string]interface{}, encode func(string) string) string {
+	var parts []string
+	for _, spec := range specs {
+		parts = append(parts, components(op, spec, values[spec.name], encode)...)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return op.first + strings.Join(parts, op.sep)
+}
+
+// components returns the zero or more joinable strings that a single varspec
+// contributes to an expansion, already including any "name=" prefix the
+// operator requires.
+func components(op operator, spec varspec, value interface{}, encode func(string) string) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{namedComponent(op, spec.name, truncate(v, spec.maxLength), encode)}
+	case []string:
+		if len(v) == 0 {
+			return nil
+		}
+		if spec.explode {
+			out := make([]string, len(v))
+			for i, e := range v {
+				out[i] = namedComponent(op, spec.name, e, encode)
+			}
+			return out
+		}
+		enc := make([]string, len(v))
+		for i, e := range v {
+			enc[i] = encode(e)
+		}
+		return []string{namedComponent(op, spec.name, strings.Join(enc, ","), passthrough)}
+	case map[string]string:
+		if len(v) == 0 {
+			return nil
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if spec.explode {
+			out := make([]string, 0, len(keys))
+			for _, k := range keys {
+				out = append(out, namedComponent(op, k, v[k], encode))
+			}
+			return out
+		}
+		kv := make([]string, 0, len(keys)*2)
+		for _, k := range keys {
+			kv = append(kv, encode(k), encode(v[k]))
+		}
+		return []string{namedComponent(op, spec.name, strings.Join(kv, ","), passthrough)}
+	default:
+		return nil
+	}
+}
+
+func passthrough(s string) string { return s }
+
+func namedComponent(op operator, name, value string, encode func(string) string) string {
+	encoded := encode(value)
+	if !op.named {
+		return encoded
+	}
+	if value == "" {
+		return name + op.ifemp
+	}
+	return name + "=" + encoded
+}
+
+func truncate(s string, maxLength int) string {
+	if maxLength <= 0 {
+		return s
+	}
+	r := []rune(s)
+	if len(r) <= maxLength {
+		return s
+	}
+	return string(r[:maxLength])
+}
+
+func isUnreserved(b byte) bool {
+	switch {
+	case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9':
+		return true
+	case b == '-' || b == '.' || b == '_' || b == '~':
+		return true
+	}
+	return false
+}
+
+func isReserved(b byte) bool {
+	switch b {
+	case ':', '/', '?', '#', '[', ']', '@',
+		'!', '$', '&', '\'', '(', ')', '*', '+', ',', ';', '=':
+		return true
+	}
+	return false
+}
+
+func isHex(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+// pctEncode percent-encodes s. If allowReserved is true, reserved characters
+// and already percent-encoded triplets ("%2F") are passed through unchanged,
+// matching the `+` and `#` RFC 6570 operators; otherwise every character
+// outside the unreserved set, including "%", is encoded.
+func pctEncode(s string, allowReserved bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case isUnreserved(c):
+			b.WriteByte(c)
+		case allowReserved && isReserved(c):
+			b.WriteByte(c)
+		case allowReserved && c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,322 @@
+// Copyright 2023 Google LLC. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package googleapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResumableServer simulates a resumable-upload session: it records the
+// byte range of every PUT it receives, tracks the contiguous prefix of the
+// object received so far (its "coverage"), and 200s once coverage reaches
+// total. A query PUT ("bytes */N") reports the same coverage without
+// accepting any data.
+//
+// If reject is set, a PUT whose range doesn't start exactly at the current
+// coverage is refused (its bytes are discarded) rather than buffered,
+// mimicking a server that can't accept out-of-order ranges.
+type fakeResumableServer struct {
+	mu        sync.Mutex
+	total     int64
+	received  []byte
+	ranges    []int64 // accepted chunk sizes, in arrival order
+	failAt    map[int64]bool
+	intervals [][2]int64 // merged, sorted, non-overlapping [start, end) ranges received so far
+	reject    bool
+}
+
+func newFakeResumableServer(total int64) *fakeResumableServer {
+	return &fakeResumableServer{total: total, received: make([]byte, total)}
+}
+
+func (s *fakeResumableServer) handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var start, end, total int64
+		cr := r.Header.Get("Content-Range")
+		if cr == "" {
+			http.Error(w, "missing Content-Range", http.StatusBadRequest)
+			return
+		}
+		if _, err := fmt.Sscanf(cr, "bytes %d-%d/%d", &start, &end, &total); err != nil {
+			// Query request: "bytes */N".
+			s.mu.Lock()
+			coverage := s.coverage()
+			s.mu.Unlock()
+			s.respond(w, coverage)
+			return
+		}
+
+		s.mu.Lock()
+		if s.failAt[start] {
+			delete(s.failAt, start)
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if !s.reject || start == s.coverage() {
+			buf := new(bytes.Buffer)
+			buf.ReadFrom(r.Body)
+			copy(s.received[start:end+1], buf.Bytes())
+			s.ranges = append(s.ranges, end-start+1)
+			s.addRange(start, end+1)
+		} else {
+			io.Copy(io.Discard, r.Body)
+		}
+		coverage := s.coverage()
+		s.mu.Unlock()
+		s.respond(w, coverage)
+	}
+}
+
+// respond writes the terminal 200 once coverage reaches s.total, otherwise
+// a 308 reporting coverage as the committed range.
+func (s *fakeResumableServer) respond(w http.ResponseWriter, coverage int64) {
+	if coverage >= s.total {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if coverage > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", coverage-1))
+	}
+	w.WriteHeader(308)
+}
+
+// coverage returns the length of the contiguous prefix of the object
+// received so far. s.mu must be held by the caller.
+func (s *fakeResumableServer) coverage() int64 {
+	if len(s.intervals) == 0 || s.intervals[0][0] != 0 {
+		return 0
+	}
+	return s.intervals[0][1]
+}
+
+// addRange merges [start, end) into s.intervals. s.mu must be held by the
+// caller.
+func (s *fakeResumableServer) addRange(start, end int64) {
+	s.intervals = append(s.intervals, [2]int64{start, end})
+	sort.Slice(s.intervals, func(i, j int) bool { return s.intervals[i][0] < s.intervals[j][0] })
+	merged := s.intervals[:1]
+	for _, iv := range s.intervals[1:] {
+		last := &merged[len(merged)-1]
+		if iv[0] <= last[1] {
+			if iv[1] > last[1] {
+				last[1] = iv[1]
+			}
+		} else {
+			merged = append(merged, iv)
+		}
+	}
+	s.intervals = merged
+}
+
+func TestResumableUploadAdaptiveChunkSizeGrows(t *testing.T) {
+	const total = 4 * 1024 * 1024
+	data := bytes.Repeat([]byte{'x'}, total)
+
+	srv := newFakeResumableServer(total)
+	ts := httptest.NewServer(srv.handler())
+	defer ts.Close()
+
+	u := &ResumableUploader{
+		Media: bytes.NewReader(data),
+		Size:  total,
+		Options: &MediaOptions{
+			ChunkSize: MinUploadChunkSize,
+		},
+	}
+	AdaptiveChunkSize(MinUploadChunkSize, 1024*1024).setOptions(u.Options)
+
+	resp, err := u.Upload(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("final status = %d, want 200", resp.StatusCode)
+	}
+
+	srv.mu.Lock()
+	ranges := append([]int64(nil), srv.ranges...)
+	srv.mu.Unlock()
+
+	if len(ranges) == 0 {
+		t.Fatal("server received no chunks")
+	}
+	// The very last chunk is whatever remainder is left once the ramp-up
+	// overshoots total; every chunk before it should be non-decreasing on
+	// an all-fast upload.
+	for i := 1; i < len(ranges)-1; i++ {
+		if ranges[i] < ranges[i-1] {
+			t.Errorf("chunk %d size %d is smaller than chunk %d size %d; want a non-decreasing trajectory on an all-fast upload", i, ranges[i], i-1, ranges[i-1])
+		}
+	}
+	var max int64
+	for _, r := range ranges {
+		if r > max {
+			max = r
+		}
+	}
+	if max > 1024*1024 {
+		t.Errorf("chunk size grew to %d, want capped at %d", max, 1024*1024)
+	}
+
+	var sum int64
+	for _, r := range ranges {
+		sum += r
+	}
+	if sum != total {
+		t.Errorf("server received %d bytes total, want %d", sum, total)
+	}
+	if !bytes.Equal(srv.received, data) {
+		t.Error("reassembled upload does not match source data")
+	}
+}
+
+func TestResumableUploadShrinksOnServerError(t *testing.T) {
+	const total = 2 * 1024 * 1024
+	data := bytes.Repeat([]byte{'y'}, total)
+
+	srv := newFakeResumableServer(total)
+	srv.failAt = map[int64]bool{0: true} // first chunk's first attempt fails
+	ts := httptest.NewServer(srv.handler())
+	defer ts.Close()
+
+	opts := &MediaOptions{ChunkSize: 1024 * 1024, ChunkRetryBudget: 5 * time.Second}
+	AdaptiveChunkSize(MinUploadChunkSize, 1024*1024).setOptions(opts)
+	u := &ResumableUploader{Media: bytes.NewReader(data), Size: total, Options: opts}
+
+	resp, err := u.Upload(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("final status = %d, want 200", resp.StatusCode)
+	}
+	if !bytes.Equal(srv.received, data) {
+		t.Error("reassembled upload does not match source data")
+	}
+	// The failed 1 MiB attempt is not recorded; the retry at offset 0 is
+	// the first accepted chunk and must have been halved.
+	srv.mu.Lock()
+	first := srv.ranges[0]
+	srv.mu.Unlock()
+	if first != 512*1024 {
+		t.Errorf("first accepted chunk size = %d, want %d (halved after the 503)", first, 512*1024)
+	}
+}
+
+// patternData returns total bytes where each byte encodes its own offset,
+// so that a misdirected or duplicated chunk range is detectable by content
+// rather than just by length.
+func patternData(total int) []byte {
+	data := make([]byte, total)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+func TestResumableUploadParallelDeliversAllBytesExactlyOnce(t *testing.T) {
+	const total = 2 * 1024 * 1024
+	data := patternData(total)
+
+	srv := newFakeResumableServer(total)
+	ts := httptest.NewServer(srv.handler())
+	defer ts.Close()
+
+	opts := &MediaOptions{ChunkSize: 256 * 1024}
+	ParallelChunks(4).setOptions(opts)
+	u := &ResumableUploader{Media: bytes.NewReader(data), Size: total, Options: opts}
+
+	resp, err := u.Upload(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("final status = %d, want 200", resp.StatusCode)
+	}
+	if !bytes.Equal(srv.received, data) {
+		t.Error("reassembled upload does not match source data")
+	}
+
+	srv.mu.Lock()
+	var sum int64
+	for _, r := range srv.ranges {
+		sum += r
+	}
+	srv.mu.Unlock()
+	if sum != total {
+		t.Errorf("server accepted %d bytes across all chunk PUTs, want exactly %d (no duplicate delivery)", sum, total)
+	}
+}
+
+func TestResumableUploadParallelIgnoresAdaptiveShrinkWithinPartition(t *testing.T) {
+	const total = 2 * 1024 * 1024
+	data := patternData(total)
+
+	srv := newFakeResumableServer(total)
+	srv.failAt = map[int64]bool{256 * 1024: true} // second partition's first attempt fails
+	ts := httptest.NewServer(srv.handler())
+	defer ts.Close()
+
+	opts := &MediaOptions{ChunkSize: 256 * 1024, ChunkRetryBudget: 5 * time.Second}
+	AdaptiveChunkSize(MinUploadChunkSize, 1024*1024).setOptions(opts)
+	ParallelChunks(4).setOptions(opts)
+	u := &ResumableUploader{Media: bytes.NewReader(data), Size: total, Options: opts}
+
+	resp, err := u.Upload(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("final status = %d, want 200", resp.StatusCode)
+	}
+	if !bytes.Equal(srv.received, data) {
+		t.Error("reassembled upload does not match source data; a shrunk partition must have dropped its tail bytes")
+	}
+
+	srv.mu.Lock()
+	var sum int64
+	for _, r := range srv.ranges {
+		sum += r
+	}
+	srv.mu.Unlock()
+	if sum != total {
+		t.Errorf("server accepted %d bytes across all chunk PUTs, want exactly %d (adaptive shrink must not leave a partition's tail undelivered)", sum, total)
+	}
+}
+
+func TestResumableUploadParallelFallsBackOnRejectedOutOfOrder(t *testing.T) {
+	const total = 1024 * 1024
+	data := patternData(total)
+
+	srv := newFakeResumableServer(total)
+	srv.reject = true // server only accepts ranges starting at its current coverage
+	ts := httptest.NewServer(srv.handler())
+	defer ts.Close()
+
+	opts := &MediaOptions{ChunkSize: 256 * 1024}
+	ParallelChunks(4).setOptions(opts)
+	u := &ResumableUploader{Media: bytes.NewReader(data), Size: total, Options: opts}
+
+	resp, err := u.Upload(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("final status = %d, want 200", resp.StatusCode)
+	}
+	if !bytes.Equal(srv.received, data) {
+		t.Error("reassembled upload does not match source data after falling back to sequential")
+	}
+}
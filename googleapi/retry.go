@@ -0,0 +1,283 @@
+// Copyright 2023 Google LLC. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package googleapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// DefaultRetriableStatusCodes are the HTTP status codes RetryTransport
+// retries by default.
+var DefaultRetriableStatusCodes = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// DefaultRetriableRPCStatuses are the google.rpc Status codes (as reported
+// in the JSON error body's "status" field) that RetryTransport retries by
+// default, in addition to DefaultRetriableStatusCodes.
+var DefaultRetriableRPCStatuses = map[string]bool{
+	"UNAVAILABLE":        true,
+	"RESOURCE_EXHAUSTED": true,
+	"ABORTED":            true,
+	"INTERNAL":           true,
+}
+
+// RetryTransport is an http.RoundTripper that retries idempotent requests
+// that fail with a retriable error, honoring any server-supplied
+// google.rpc.RetryInfo or Retry-After guidance about how long to wait. A
+// request is only retried if its method is inherently idempotent (GET,
+// HEAD, PUT, DELETE, OPTIONS, TRACE) or it carries a non-empty
+// Idempotency-Key header; a failed POST or PATCH without that header is
+// returned as-is rather than risk duplicating its side effects.
+type RetryTransport struct {
+	// Base is the underlying transport used to make requests. If nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// MaxAttempts is the maximum number of times a request is sent,
+	// including the first attempt. It defaults to 5.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry. It defaults to
+	// one second and doubles on each subsequent attempt.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the computed delay between attempts, including any
+	// server-supplied RetryInfo or Retry-After value. It defaults to 32
+	// seconds.
+	MaxBackoff time.Duration
+
+	// RetriableStatusCodes overrides DefaultRetriableStatusCodes.
+	RetriableStatusCodes map[int]bool
+
+	// RetriableRPCStatuses overrides DefaultRetriableRPCStatuses.
+	RetriableRPCStatuses map[string]bool
+
+	// OnRetry, if non-nil, is called before sleeping ahead of every retry
+	// so callers can plug in metrics without wrapping the transport.
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+func (t *RetryTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *RetryTransport) maxAttempts() int {
+	if t.MaxAttempts > 0 {
+		return t.MaxAttempts
+	}
+	return 5
+}
+
+func (t *RetryTransport) initialBackoff() time.Duration {
+	if t.InitialBackoff > 0 {
+		return t.InitialBackoff
+	}
+	return time.Second
+}
+
+func (t *RetryTransport) maxBackoff() time.Duration {
+	if t.MaxBackoff > 0 {
+		return t.MaxBackoff
+	}
+	return 32 * time.Second
+}
+
+func (t *RetryTransport) retriableStatusCode(code int) bool {
+	codes := t.RetriableStatusCodes
+	if codes == nil {
+		codes = DefaultRetriableStatusCodes
+	}
+	return codes[code]
+}
+
+func (t *RetryTransport) retriableRPCStatus(status string) bool {
+	if status == "" {
+		return false
+	}
+	statuses := t.RetriableRPCStatuses
+	if statuses == nil {
+		statuses = DefaultRetriableRPCStatuses
+	}
+	return statuses[status]
+}
+
+// isIdempotentMethod reports whether method is inherently safe to retry
+// without an explicit Idempotency-Key.
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	canRewind := req.Body == nil || req.GetBody != nil
+	idempotent := isIdempotentMethod(req.Method) || req.Header.Get("Idempotency-Key") != ""
+	maxAttempts := t.maxAttempts()
+	if !canRewind || !idempotent {
+		maxAttempts = 1
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, berr := req.GetBody()
+			if berr != nil {
+				return resp, err
+			}
+			req.Body = body
+		}
+
+		resp, err = t.base().RoundTrip(req)
+		if err != nil {
+			if attempt == maxAttempts-1 || !isRetriableError(err) {
+				return resp, err
+			}
+			delay := t.backoff(attempt, 0, nil)
+			t.notify(attempt+1, err, delay)
+			time.Sleep(delay)
+			continue
+		}
+
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			return resp, nil
+		}
+
+		body, rerr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if rerr != nil {
+			return resp, nil
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		status := extractRPCStatus(body)
+		if attempt == maxAttempts-1 || !(t.retriableStatusCode(resp.StatusCode) || t.retriableRPCStatus(status)) {
+			return resp, nil
+		}
+
+		gerr := CheckResponseWithBody(resp, body).(*Error)
+
+		var retryDelay time.Duration
+		if ri, ok := gerr.RetryInfo(); ok {
+			retryDelay = ri.RetryDelay
+		}
+		delay := t.backoff(attempt, retryDelay, resp.Header)
+		t.notify(attempt+1, gerr, delay)
+		time.Sleep(delay)
+	}
+	return resp, err
+}
+
+func (t *RetryTransport) notify(attempt int, err error, delay time.Duration) {
+	if t.OnRetry != nil {
+		t.OnRetry(attempt, err, delay)
+	}
+}
+
+// backoff computes the delay before the next attempt as the larger of a
+// full-jittered exponential backoff, the server-supplied retryDelay, and
+// any Retry-After header, capped at MaxBackoff. Jitter is applied only to
+// the exponential component, so a server-mandated retryDelay or
+// Retry-After is never randomized down below what was asked for.
+func (t *RetryTransport) backoff(attempt int, retryDelay time.Duration, header http.Header) time.Duration {
+	d := t.initialBackoff()
+	for i := 0; i < attempt; i++ {
+		d *= 2
+	}
+	if d > 0 {
+		d = time.Duration(rand.Int63n(int64(d) + 1))
+	}
+	if retryDelay > d {
+		d = retryDelay
+	}
+	if ra := parseRetryAfter(header); ra > d {
+		d = ra
+	}
+	if max := t.maxBackoff(); d > max {
+		d = max
+	}
+	return d
+}
+
+// isRetriableError reports whether err is a transient transport failure
+// worth retrying: timeouts, connection resets/refusals, and other
+// *net.OpError conditions, in addition to an unexpectedly closed
+// connection.
+func isRetriableError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// parseRetryAfter parses an HTTP Retry-After header, which may be either a
+// number of seconds or an HTTP-date. It returns zero if header is nil or
+// the value can't be parsed.
+func parseRetryAfter(header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+	v := header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if when, err := http.ParseTime(v); err == nil {
+		if d := time.Until(when); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// extractRPCStatus pulls the top-level google.rpc Status code (e.g.
+// "UNAVAILABLE") out of a JSON error body, independent of googleapi.Error,
+// so that parsing it never changes Error's JSON-decoded shape.
+func extractRPCStatus(body []byte) string {
+	var v struct {
+		Error struct {
+			Status string `json:"status"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &v) != nil {
+		return ""
+	}
+	return v.Error.Status
+}
@@ -0,0 +1,234 @@
+// Copyright 2023 Google LLC. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package googleapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransportRetriesRetriableStatus(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"code":503,"message":"backend unavailable","status":"UNAVAILABLE"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	var retries int32
+	rt := &RetryTransport{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     5 * time.Millisecond,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			atomic.AddInt32(&retries, 1)
+		},
+	}
+	client := &http.Client{Transport: rt}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("got status %d, body %q; want 200, \"ok\"", resp.StatusCode, body)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("server handled %d calls, want 3", got)
+	}
+	if got := atomic.LoadInt32(&retries); got != 2 {
+		t.Errorf("OnRetry called %d times, want 2", got)
+	}
+}
+
+func TestRetryTransportRetriesRetriableRPCStatusOnNonRetriableCode(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":400,"message":"backend unavailable","status":"UNAVAILABLE"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	rt := &RetryTransport{InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	client := &http.Client{Transport: rt}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("got status %d, body %q; want 200, \"ok\"", resp.StatusCode, body)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("server handled %d calls, want 2 (retry on RPC status despite non-retriable HTTP code)", got)
+	}
+}
+
+func TestRetryTransportGivesUpOnNonRetriableStatus(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":{"code":404,"message":"not found"}}`))
+	}))
+	defer srv.Close()
+
+	rt := &RetryTransport{InitialBackoff: time.Millisecond}
+	client := &http.Client{Transport: rt}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("got status %d, want 404", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("server handled %d calls, want 1 (no retry)", got)
+	}
+}
+
+func TestRetryTransportGivesUpOnNonIdempotentMethod(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	rt := &RetryTransport{InitialBackoff: time.Millisecond}
+	client := &http.Client{Transport: rt}
+
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("server handled %d calls, want 1 (no retry for non-idempotent POST)", got)
+	}
+}
+
+func TestRetryTransportRetriesNonIdempotentMethodWithIdempotencyKey(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	rt := &RetryTransport{InitialBackoff: time.Millisecond}
+	client := &http.Client{Transport: rt}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Idempotency-Key", "fixed-key-1")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("server handled %d calls, want 2 (POST retried because of Idempotency-Key)", got)
+	}
+}
+
+func TestRetryTransportRefusesNonSeekableBody(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	rt := &RetryTransport{InitialBackoff: time.Millisecond}
+	client := &http.Client{Transport: rt}
+
+	req, err := http.NewRequest(http.MethodPut, srv.URL, io.NopCloser(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.GetBody = nil // simulate a non-seekable streaming body
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("server handled %d calls, want 1 (retry refused for non-seekable body)", got)
+	}
+}
+
+func TestBackoffNeverJittersBelowServerMinimum(t *testing.T) {
+	rt := &RetryTransport{InitialBackoff: time.Millisecond, MaxBackoff: time.Hour}
+	retryDelay := 1500 * time.Millisecond
+
+	for i := 0; i < 100; i++ {
+		if got := rt.backoff(0, retryDelay, nil); got < retryDelay {
+			t.Fatalf("backoff(0, %v, nil) = %v, want >= %v (server-supplied RetryInfo must not be jittered below its value)", retryDelay, got, retryDelay)
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Retry-After", "2")
+	for i := 0; i < 100; i++ {
+		if got := rt.backoff(0, 0, header); got < 2*time.Second {
+			t.Fatalf("backoff(0, 0, Retry-After: 2) = %v, want >= 2s", got)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	h := http.Header{}
+	h.Set("Retry-After", "5")
+	if got, want := parseRetryAfter(h), 5*time.Second; got != want {
+		t.Errorf("parseRetryAfter(%q) = %v, want %v", "5", got, want)
+	}
+
+	h.Set("Retry-After", time.Now().Add(10*time.Second).UTC().Format(http.TimeFormat))
+	if got := parseRetryAfter(h); got <= 0 || got > 11*time.Second {
+		t.Errorf("parseRetryAfter(HTTP-date) = %v, want roughly 10s", got)
+	}
+
+	if got := parseRetryAfter(nil); got != 0 {
+		t.Errorf("parseRetryAfter(nil) = %v, want 0", got)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2023 Google LLC. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package option contains options for Google API clients.
+package option
+
+import "google.golang.org/api/googleapi"
+
+// ClientOption is used to configure API clients generated by this
+// repository.
+type ClientOption interface {
+	Apply(*ClientOptions)
+}
+
+// ClientOptions holds the options accumulated from a list of
+// ClientOptions passed to a generated service's constructor.
+type ClientOptions struct {
+	// RetryPolicy, if non-nil, configures the generated client to wrap
+	// its HTTP transport in a *googleapi.RetryTransport. RetryPolicy.Base
+	// is set to the client's underlying transport when the client is
+	// constructed.
+	RetryPolicy *googleapi.RetryTransport
+}
+
+type withRetryPolicy struct {
+	policy *googleapi.RetryTransport
+}
+
+func (w withRetryPolicy) Apply(o *ClientOptions) {
+	o.RetryPolicy = w.policy
+}
+
+// WithRetryPolicy returns a ClientOption that makes a generated client
+// transparently retry idempotent calls using rt. rt is used as a template:
+// its Base field is overwritten with the client's own transport, so
+// callers only need to set the retry policy fields (MaxAttempts,
+// InitialBackoff, MaxBackoff, RetriableStatusCodes, RetriableRPCStatuses,
+// OnRetry).
+func WithRetryPolicy(rt *googleapi.RetryTransport) ClientOption {
+	return withRetryPolicy{policy: rt}
+}